@@ -35,6 +35,16 @@ type StateEntry struct {
 type Block struct {
 	StartVar *autofunc.Variable
 	Entries  []StateEntry
+
+	// SoftInput, if true, treats each input to ApplyBlock (or
+	// ApplyBlockR) as a vector of log-probabilities over the
+	// alphabet rather than a one-hot selector. The transition
+	// out of each state becomes a mixture over every alphabet
+	// symbol weighted by the input's distribution, which makes
+	// the block differentiable with respect to its inputs. It
+	// defaults to false, which reproduces the original
+	// argmax-selection behavior.
+	SoftInput bool
 }
 
 // DeserializeBlock deserializes a Block.
@@ -117,6 +127,16 @@ func (b *Block) PropagateStartR(s []rnn.RState, u []rnn.RStateGrad, rg autofunc.
 }
 
 // ApplyBlock applies the block to a batch of inputs.
+//
+// Internally this builds two logSumExpRows autofunc nodes per
+// batch element, one for the output and one for the next
+// state (instead of AddLogDomain chains over every state),
+// and caches the row-wise log-softmaxes per unique input
+// symbol, since those never depend on which batch element or
+// state distribution is being processed. If b.SoftInput is
+// set, inputs are treated as log-probabilities instead of
+// one-hot selectors, in which case the transition out of
+// each state is a logMixture over every alphabet symbol.
 func (b *Block) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
 	out := &blockResult{
 		Pool:       make([]*autofunc.Variable, len(in)),
@@ -126,41 +146,106 @@ func (b *Block) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult
 		StatesOut:  make([]rnn.State, len(in)),
 	}
 
-	var softmax neuralnet.LogSoftmaxLayer
+	outputRows := b.outputRows()
+	outputCache := rowLogSoftmaxCache(outputRows)
+
+	var transCache map[int][]linalg.Vector
+	var softRows [][]autofunc.Result
+	if b.SoftInput {
+		softRows = b.softTransitionRows()
+	} else {
+		transCache = map[int][]linalg.Vector{}
+	}
+
 	for i, rawState := range s {
 		out.Pool[i] = &autofunc.Variable{
 			Vector: rawState.(blockState).State.Output(),
 		}
 		state := out.Pool[i]
-		input := maxIndex(in[i].Output())
-		var output autofunc.Result
+
 		var newStates autofunc.Result
-		for stateIdx, entry := range b.Entries {
-			outputs := softmax.Apply(entry.Output)
-			transitions := softmax.Apply(entry.Transitions[input])
-
-			probLog := autofunc.Slice(state, stateIdx, stateIdx+1)
-			scaledOut := autofunc.AddFirst(outputs, probLog)
-			scaledStates := autofunc.AddFirst(transitions, probLog)
-
-			if output == nil {
-				output = scaledOut
-				newStates = scaledStates
-			} else {
-				output = autofunc.AddLogDomain(output, scaledOut)
-				newStates = autofunc.AddLogDomain(newStates, scaledStates)
+		if b.SoftInput {
+			mixedRows := make([]autofunc.Result, len(b.Entries))
+			for stateIdx := range b.Entries {
+				mixedRows[stateIdx] = newLogMixture(in[i], softRows[stateIdx])
+			}
+			newStates = newLogMixture(state, mixedRows)
+		} else {
+			input := maxIndex(in[i].Output())
+			transRows := b.transitionRows(input)
+			cache, ok := transCache[input]
+			if !ok {
+				cache = rowLogSoftmaxCache(transRows)
+				transCache[input] = cache
 			}
+			newStates = newLogSumExpRows(state, transRows, cache)
 		}
 
+		output := newLogSumExpRows(state, outputRows, outputCache)
+
 		out.OutputRes[i] = output
-		out.OutputVecs[i] = out.OutputRes[i].Output()
+		out.OutputVecs[i] = output.Output()
 		out.StateRes[i] = newStates
-		out.StatesOut[i] = blockState{State: out.StateRes[i]}
+		out.StatesOut[i] = blockState{State: newStates}
 	}
 
 	return out
 }
 
+// outputRows collects the pre-softmax output variable of
+// every state, in state order.
+func (b *Block) outputRows() []*autofunc.Variable {
+	res := make([]*autofunc.Variable, len(b.Entries))
+	for i, e := range b.Entries {
+		res[i] = e.Output
+	}
+	return res
+}
+
+// transitionRows collects the pre-softmax transition
+// variable that fires on the given input symbol, one per
+// state, in state order.
+func (b *Block) transitionRows(input int) []*autofunc.Variable {
+	res := make([]*autofunc.Variable, len(b.Entries))
+	for i, e := range b.Entries {
+		res[i] = e.Transitions[input]
+	}
+	return res
+}
+
+// softTransitionRows log-softmaxes every transition variable
+// of every state, for use by SoftInput. Unlike transitionRows,
+// this does not depend on a particular input symbol, so
+// ApplyBlock and ApplyBlockR compute it once per call and
+// reuse it across the whole batch.
+func (b *Block) softTransitionRows() [][]autofunc.Result {
+	var softmax neuralnet.LogSoftmaxLayer
+	res := make([][]autofunc.Result, len(b.Entries))
+	for s, e := range b.Entries {
+		row := make([]autofunc.Result, len(e.Transitions))
+		for a, v := range e.Transitions {
+			row[a] = softmax.Apply(v)
+		}
+		res[s] = row
+	}
+	return res
+}
+
+// softTransitionRowsR is the R-operator counterpart of
+// softTransitionRows.
+func (b *Block) softTransitionRowsR(v autofunc.RVector) [][]autofunc.RResult {
+	var softmax neuralnet.LogSoftmaxLayer
+	res := make([][]autofunc.RResult, len(b.Entries))
+	for s, e := range b.Entries {
+		row := make([]autofunc.RResult, len(e.Transitions))
+		for a, t := range e.Transitions {
+			row[a] = softmax.ApplyR(v, autofunc.NewRVariable(t, v))
+		}
+		res[s] = row
+	}
+	return res
+}
+
 // ApplyBlockR is like ApplyBlock, but with support for
 // the R operator.
 func (b *Block) ApplyBlockR(v autofunc.RVector, s []rnn.RState,
@@ -174,7 +259,16 @@ func (b *Block) ApplyBlockR(v autofunc.RVector, s []rnn.RState,
 		StatesOut:   make([]rnn.RState, len(in)),
 	}
 
-	var softmax neuralnet.LogSoftmaxLayer
+	outputCache := newRowRCache(v, b.outputRows())
+
+	var transCache map[int]*rowRCache
+	var softRows [][]autofunc.RResult
+	if b.SoftInput {
+		softRows = b.softTransitionRowsR(v)
+	} else {
+		transCache = map[int]*rowRCache{}
+	}
+
 	for i, rawState := range s {
 		out.Pool[i] = &autofunc.Variable{
 			Vector: rawState.(blockRState).RState.Output(),
@@ -183,38 +277,40 @@ func (b *Block) ApplyBlockR(v autofunc.RVector, s []rnn.RState,
 			Variable:   out.Pool[i],
 			ROutputVec: rawState.(blockRState).RState.ROutput(),
 		}
-		input := maxIndex(in[i].Output())
-		var output autofunc.RResult
+
 		var newStates autofunc.RResult
-		for stateIdx, entry := range b.Entries {
-			outputs := softmax.ApplyR(v, autofunc.NewRVariable(entry.Output, v))
-			transitions := softmax.ApplyR(v, autofunc.NewRVariable(entry.Transitions[input], v))
-
-			probLog := autofunc.SliceR(state, stateIdx, stateIdx+1)
-			scaledOut := autofunc.AddFirstR(outputs, probLog)
-			scaledStates := autofunc.AddFirstR(transitions, probLog)
-
-			if output == nil {
-				output = scaledOut
-				newStates = scaledStates
-			} else {
-				output = autofunc.AddLogDomainR(output, scaledOut)
-				newStates = autofunc.AddLogDomainR(newStates, scaledStates)
+		if b.SoftInput {
+			mixedRows := make([]autofunc.RResult, len(b.Entries))
+			for stateIdx := range b.Entries {
+				mixedRows[stateIdx] = newLogMixtureR(in[i], softRows[stateIdx])
 			}
+			newStates = newLogMixtureR(state, mixedRows)
+		} else {
+			input := maxIndex(in[i].Output())
+			cache, ok := transCache[input]
+			if !ok {
+				cache = newRowRCache(v, b.transitionRows(input))
+				transCache[input] = cache
+			}
+			newStates = newLogSumExpRowsR(state, cache)
 		}
 
+		output := newLogSumExpRowsR(state, outputCache)
+
 		out.OutputRes[i] = output
-		out.OutputVecs[i] = out.OutputRes[i].Output()
-		out.OutputVecsR[i] = out.OutputRes[i].ROutput()
+		out.OutputVecs[i] = output.Output()
+		out.OutputVecsR[i] = output.ROutput()
 		out.StateRes[i] = newStates
-		out.StatesOut[i] = blockRState{RState: out.StateRes[i]}
+		out.StatesOut[i] = blockRState{RState: newStates}
 	}
 
 	return out
 }
 
 // Parameters returns all of the variables involved in
-// this model.
+// this model. SoftInput does not add any parameters of its
+// own; it only changes how the existing Entries variables
+// are combined with the block's input.
 func (b *Block) Parameters() []*autofunc.Variable {
 	res := []*autofunc.Variable{b.StartVar}
 	for _, e := range b.Entries {
@@ -0,0 +1,112 @@
+package statebrain
+
+import (
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func benchmarkApplyBlock(b *testing.B, stateCount, alphabetSize, batchSize int) {
+	block := NewBlock(alphabetSize, stateCount)
+
+	states := make([]rnn.State, batchSize)
+	ins := make([]autofunc.Result, batchSize)
+	for i := 0; i < batchSize; i++ {
+		states[i] = block.StartState()
+		vec := make(linalg.Vector, alphabetSize)
+		vec[i%alphabetSize] = 1
+		ins[i] = &autofunc.Variable{Vector: vec}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		block.ApplyBlock(states, ins)
+	}
+}
+
+func BenchmarkApplyBlock64(b *testing.B) {
+	benchmarkApplyBlock(b, 64, 256, 8)
+}
+
+func BenchmarkApplyBlock256(b *testing.B) {
+	benchmarkApplyBlock(b, 256, 256, 8)
+}
+
+// applyBlockOldLoop reproduces ApplyBlock's pre-fusion
+// implementation, which built two softmaxes plus two
+// AddFirst/AddLogDomain chains per state instead of the two
+// logSumExpRows nodes ApplyBlock now uses. It exists only so the
+// benchmarks below can measure the speedup that fusion gives;
+// it is not used outside this file.
+func applyBlockOldLoop(b *Block, s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	out := &blockResult{
+		Pool:       make([]*autofunc.Variable, len(in)),
+		OutputRes:  make([]autofunc.Result, len(in)),
+		StateRes:   make([]autofunc.Result, len(in)),
+		OutputVecs: make([]linalg.Vector, len(in)),
+		StatesOut:  make([]rnn.State, len(in)),
+	}
+
+	var softmax neuralnet.LogSoftmaxLayer
+	for i, rawState := range s {
+		out.Pool[i] = &autofunc.Variable{
+			Vector: rawState.(blockState).State.Output(),
+		}
+		state := out.Pool[i]
+		input := maxIndex(in[i].Output())
+		var output autofunc.Result
+		var newStates autofunc.Result
+		for stateIdx, entry := range b.Entries {
+			outputs := softmax.Apply(entry.Output)
+			transitions := softmax.Apply(entry.Transitions[input])
+
+			probLog := autofunc.Slice(state, stateIdx, stateIdx+1)
+			scaledOut := autofunc.AddFirst(outputs, probLog)
+			scaledStates := autofunc.AddFirst(transitions, probLog)
+
+			if output == nil {
+				output = scaledOut
+				newStates = scaledStates
+			} else {
+				output = autofunc.AddLogDomain(output, scaledOut)
+				newStates = autofunc.AddLogDomain(newStates, scaledStates)
+			}
+		}
+
+		out.OutputRes[i] = output
+		out.OutputVecs[i] = out.OutputRes[i].Output()
+		out.StateRes[i] = newStates
+		out.StatesOut[i] = blockState{State: out.StateRes[i]}
+	}
+
+	return out
+}
+
+func benchmarkApplyBlockOld(b *testing.B, stateCount, alphabetSize, batchSize int) {
+	block := NewBlock(alphabetSize, stateCount)
+
+	states := make([]rnn.State, batchSize)
+	ins := make([]autofunc.Result, batchSize)
+	for i := 0; i < batchSize; i++ {
+		states[i] = block.StartState()
+		vec := make(linalg.Vector, alphabetSize)
+		vec[i%alphabetSize] = 1
+		ins[i] = &autofunc.Variable{Vector: vec}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		applyBlockOldLoop(block, states, ins)
+	}
+}
+
+func BenchmarkApplyBlockOld64(b *testing.B) {
+	benchmarkApplyBlockOld(b, 64, 256, 8)
+}
+
+func BenchmarkApplyBlockOld256(b *testing.B) {
+	benchmarkApplyBlockOld(b, 256, 256, 8)
+}
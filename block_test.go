@@ -10,3 +10,9 @@ func TestBlock(t *testing.T) {
 	block := NewBlock(4, 3)
 	rnntest.NewChecker4In(block, block).FullCheck(t)
 }
+
+func TestBlockSoftInput(t *testing.T) {
+	block := NewBlock(4, 3)
+	block.SoftInput = true
+	rnntest.NewChecker4In(block, block).FullCheck(t)
+}
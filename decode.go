@@ -0,0 +1,146 @@
+package statebrain
+
+import (
+	"math"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// Decode finds the most likely hidden state trajectory for
+// an observed input/output sequence pair using the Viterbi
+// algorithm in the log domain. It returns the trajectory and
+// its log-likelihood.
+//
+// This mirrors ApplyBlock's semantics: state S_t emits
+// output[t], and the transition out of S_t into S_{t+1} is
+// driven by input[t]. So the step from delta[step-1] to
+// delta[step] uses input[step-1], not input[step].
+//
+// The input and output sequences must be the same length. If
+// they are empty (or mismatched), Decode returns a nil path
+// rather than indexing into them.
+func (b *Block) Decode(input, output []byte) (path []int, logLikelihood float64) {
+	numSteps := len(input)
+	if numSteps == 0 || len(output) != numSteps {
+		return nil, 0
+	}
+	numStates := len(b.Entries)
+
+	outLog := make([][]float64, numStates)
+	for s, e := range b.Entries {
+		outLog[s] = logSoftmax(e.Output.Vector)
+	}
+
+	delta := make([]float64, numStates)
+	startLog := logSoftmax(b.StartVar.Vector)
+	for s := range delta {
+		delta[s] = startLog[s] + outLog[s][output[0]]
+	}
+
+	backPointers := make([][]int, numSteps)
+	for step := 1; step < numSteps; step++ {
+		transLog := make([][]float64, numStates)
+		for s := 0; s < numStates; s++ {
+			transLog[s] = logSoftmax(b.Entries[s].Transitions[input[step-1]].Vector)
+		}
+
+		newDelta := make([]float64, numStates)
+		back := make([]int, numStates)
+		for sp := 0; sp < numStates; sp++ {
+			best := math.Inf(-1)
+			bestState := 0
+			for s := 0; s < numStates; s++ {
+				v := delta[s] + transLog[s][sp]
+				if v > best {
+					best = v
+					bestState = s
+				}
+			}
+			newDelta[sp] = best + outLog[sp][output[step]]
+			back[sp] = bestState
+		}
+		backPointers[step] = back
+		delta = newDelta
+	}
+
+	last := maxIndex(linalg.Vector(delta))
+	path = make([]int, numSteps)
+	path[numSteps-1] = last
+	for step := numSteps - 1; step > 0; step-- {
+		path[step-1] = backPointers[step][path[step]]
+	}
+	return path, delta[last]
+}
+
+// SampleParticles runs a bootstrap particle filter over the
+// same forward recurrence used by Decode (see its comment
+// for the input/output timestep convention), returning n
+// approximate hidden-state trajectories distributed
+// according to the posterior over states given the input and
+// output sequences. It is meant as a cheaper alternative to
+// Decode for models with too many states for exact Viterbi.
+//
+// As with Decode, input and output must be the same length; if
+// they are empty (or mismatched), SampleParticles returns n
+// empty trajectories rather than indexing into them.
+func (b *Block) SampleParticles(input, output []byte, n int) [][]int {
+	numSteps := len(input)
+	if numSteps == 0 || len(output) != numSteps {
+		particles := make([][]int, n)
+		for i := range particles {
+			particles[i] = []int{}
+		}
+		return particles
+	}
+	numStates := len(b.Entries)
+
+	outLog := make([][]float64, numStates)
+	for s, e := range b.Entries {
+		outLog[s] = logSoftmax(e.Output.Vector)
+	}
+
+	startLog := logSoftmax(b.StartVar.Vector)
+	startProbs := expNormalize(startLog)
+
+	particles := make([][]int, n)
+	weights := make([]float64, n)
+	for i := range particles {
+		s := sampleIndex(startProbs)
+		particles[i] = []int{s}
+		weights[i] = outLog[s][output[0]]
+	}
+	particles = resampleParticles(particles, weights, n)
+
+	for step := 1; step < numSteps; step++ {
+		transProbs := make([][]float64, numStates)
+		for s := 0; s < numStates; s++ {
+			transProbs[s] = expNormalize(logSoftmax(b.Entries[s].Transitions[input[step-1]].Vector))
+		}
+
+		weights = make([]float64, n)
+		for i, particle := range particles {
+			prevState := particle[len(particle)-1]
+			nextState := sampleIndex(transProbs[prevState])
+			particles[i] = append(particle, nextState)
+			weights[i] = outLog[nextState][output[step]]
+		}
+		particles = resampleParticles(particles, weights, n)
+	}
+
+	return particles
+}
+
+// resampleParticles draws n trajectories with replacement
+// from particles, proportional to their (unnormalized)
+// log-domain weights.
+func resampleParticles(particles [][]int, logWeights []float64, n int) [][]int {
+	probs := expNormalize(logWeights)
+	res := make([][]int, n)
+	for i := range res {
+		src := particles[sampleIndex(probs)]
+		traj := make([]int, len(src))
+		copy(traj, src)
+		res[i] = traj
+	}
+	return res
+}
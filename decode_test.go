@@ -0,0 +1,110 @@
+package statebrain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// newFlipFlopBlock builds a 2-state, 2-symbol block that
+// always flips state on any input (regardless of its value)
+// and emits its own state index with near-certainty. It is
+// saturated enough that Decode's path is unambiguous, so the
+// expected output can be worked out by hand.
+func newFlipFlopBlock() *Block {
+	const logit = 20.0
+
+	newOutput := func(certain int) *autofunc.Variable {
+		vec := make(linalg.Vector, 2)
+		vec[certain] = logit
+		vec[1-certain] = -logit
+		return &autofunc.Variable{Vector: vec}
+	}
+	newTransition := func(next int) *autofunc.Variable {
+		vec := make(linalg.Vector, 2)
+		vec[next] = logit
+		vec[1-next] = -logit
+		return &autofunc.Variable{Vector: vec}
+	}
+
+	block := &Block{
+		StartVar: &autofunc.Variable{Vector: linalg.Vector{logit, -logit}},
+		Entries: []StateEntry{
+			{
+				Output:      newOutput(0),
+				Transitions: []*autofunc.Variable{newTransition(1), newTransition(1)},
+			},
+			{
+				Output:      newOutput(1),
+				Transitions: []*autofunc.Variable{newTransition(0), newTransition(0)},
+			},
+		},
+	}
+	return block
+}
+
+func TestDecodeFlipFlop(t *testing.T) {
+	block := newFlipFlopBlock()
+
+	input := []byte{0, 0, 0, 0}
+	output := []byte{0, 1, 0, 1}
+
+	path, _ := block.Decode(input, output)
+	expected := []int{0, 1, 0, 1}
+	if !reflect.DeepEqual(path, expected) {
+		t.Fatalf("expected path %v, got %v", expected, path)
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	block := NewBlock(4, 3)
+
+	path, ll := block.Decode(nil, nil)
+	if path != nil || ll != 0 {
+		t.Fatalf("expected nil path and 0 log-likelihood for empty input, got %v, %f", path, ll)
+	}
+
+	path, ll = block.Decode([]byte{0, 1}, []byte{0})
+	if path != nil || ll != 0 {
+		t.Fatalf("expected nil path and 0 log-likelihood for mismatched lengths, got %v, %f",
+			path, ll)
+	}
+
+	particles := block.SampleParticles(nil, nil, 5)
+	if len(particles) != 5 {
+		t.Fatalf("expected 5 particles, got %d", len(particles))
+	}
+	for _, p := range particles {
+		if len(p) != 0 {
+			t.Fatalf("expected empty particle, got %v", p)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	block := NewBlock(4, 3)
+	input := []byte{0, 1, 2, 3}
+	output := []byte{1, 2, 3, 0}
+
+	path, _ := block.Decode(input, output)
+	if len(path) != len(input) {
+		t.Fatalf("expected path of length %d, got %d", len(input), len(path))
+	}
+	for _, s := range path {
+		if s < 0 || s >= len(block.Entries) {
+			t.Fatalf("state index %d out of range", s)
+		}
+	}
+
+	particles := block.SampleParticles(input, output, 10)
+	if len(particles) != 10 {
+		t.Fatalf("expected 10 particles, got %d", len(particles))
+	}
+	for _, p := range particles {
+		if len(p) != len(input) {
+			t.Fatalf("expected particle of length %d, got %d", len(input), len(p))
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package statebrain
+
+import (
+	"math"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// smoothingCount is the add-one-style smoothing applied to
+// every EM count before it is turned back into a
+// log-probability, so that symbols or transitions that never
+// occur in the corpus don't collapse to -Inf.
+const smoothingCount = 1e-3
+
+// EMInitialize pre-trains a Block with Baum-Welch on a corpus
+// of byte sequences, treating each sequence as both the
+// observed output and the input that drives transitions (the
+// same convention ApplyBlock uses). A discrete-state model
+// like this one is exactly an input-output HMM, so a few EM
+// sweeps give gradient descent a much better starting point
+// than the random initialization from NewBlock.
+func (b *Block) EMInitialize(corpus [][]byte, iters int) {
+	numStates := len(b.Entries)
+	if numStates == 0 {
+		return
+	}
+	alphabetSize := len(b.Entries[0].Output.Vector)
+
+	for iter := 0; iter < iters; iter++ {
+		startLog := logSoftmax(b.StartVar.Vector)
+		outLog := make([]linalg.Vector, numStates)
+		for s, e := range b.Entries {
+			outLog[s] = linalg.Vector(logSoftmax(e.Output.Vector))
+		}
+		transLogFor := b.cachedTransitionLog()
+
+		startCounts := make([]float64, numStates)
+		outputCounts := make([][]float64, numStates)
+		transCounts := make([][][]float64, numStates)
+		transDenom := make([][]float64, numStates)
+		for s := 0; s < numStates; s++ {
+			outputCounts[s] = make([]float64, alphabetSize)
+			transDenom[s] = make([]float64, alphabetSize)
+			transCounts[s] = make([][]float64, alphabetSize)
+			for a := 0; a < alphabetSize; a++ {
+				transCounts[s][a] = make([]float64, numStates)
+			}
+		}
+
+		for _, seq := range corpus {
+			if len(seq) == 0 {
+				continue
+			}
+			alpha := b.forwardLogAlpha(seq, startLog, outLog, transLogFor)
+			beta := b.backwardLogBeta(seq, outLog, transLogFor)
+			logZ := logSumExp(alpha[len(seq)-1])
+
+			for step, y := range seq {
+				for s := 0; s < numStates; s++ {
+					g := math.Exp(alpha[step][s] + beta[step][s] - logZ)
+					outputCounts[s][y] += g
+					if step == 0 {
+						startCounts[s] += g
+					}
+				}
+			}
+
+			for step := 1; step < len(seq); step++ {
+				// The transition out of the state at step-1 is
+				// driven by seq[step-1], the same convention
+				// ApplyBlock uses, so that's the x whose
+				// Transitions[x] gets re-estimated from this xi.
+				x := int(seq[step-1])
+				trans := transLogFor(x)
+				for s := 0; s < numStates; s++ {
+					transDenom[s][x] += math.Exp(alpha[step-1][s] + beta[step-1][s] - logZ)
+					for sp := 0; sp < numStates; sp++ {
+						xi := alpha[step-1][s] + trans[s][sp] + outLog[sp][seq[step]] + beta[step][sp]
+						transCounts[s][x][sp] += math.Exp(xi - logZ)
+					}
+				}
+			}
+		}
+
+		b.StartVar.Vector = normalizeCounts(startCounts, smoothingCount)
+		for s, e := range b.Entries {
+			e.Output.Vector = normalizeCounts(outputCounts[s], smoothingCount)
+			for x, denom := range transDenom[s] {
+				total := denom + smoothingCount*float64(numStates)
+				row := make(linalg.Vector, numStates)
+				for sp := 0; sp < numStates; sp++ {
+					row[sp] = math.Log((transCounts[s][x][sp] + smoothingCount) / total)
+				}
+				e.Transitions[x].Vector = row
+			}
+		}
+	}
+}
+
+// LogLikelihood returns the total log-probability of the
+// corpus under the block's current parameters, computed with
+// the same forward recurrence EMInitialize uses.
+func (b *Block) LogLikelihood(corpus [][]byte) float64 {
+	startLog := logSoftmax(b.StartVar.Vector)
+	outLog := make([]linalg.Vector, len(b.Entries))
+	for s, e := range b.Entries {
+		outLog[s] = linalg.Vector(logSoftmax(e.Output.Vector))
+	}
+	transLogFor := b.cachedTransitionLog()
+
+	var total float64
+	for _, seq := range corpus {
+		if len(seq) == 0 {
+			continue
+		}
+		alpha := b.forwardLogAlpha(seq, startLog, outLog, transLogFor)
+		total += logSumExp(alpha[len(seq)-1])
+	}
+	return total
+}
+
+// cachedTransitionLog returns a function that log-softmaxes
+// Entries[*].Transitions[x] for a given input symbol x,
+// memoizing the result since it is the same for every
+// sequence and timestep that share that symbol.
+func (b *Block) cachedTransitionLog() func(int) []linalg.Vector {
+	cache := map[int][]linalg.Vector{}
+	return func(x int) []linalg.Vector {
+		if v, ok := cache[x]; ok {
+			return v
+		}
+		v := make([]linalg.Vector, len(b.Entries))
+		for s, e := range b.Entries {
+			v[s] = linalg.Vector(logSoftmax(e.Transitions[x].Vector))
+		}
+		cache[x] = v
+		return v
+	}
+}
+
+// forwardLogAlpha runs the log-domain forward algorithm for
+// one sequence, returning alpha_t(s) = log P(y_0..y_t,
+// state_t=s | x_0..x_t) for every t. This mirrors ApplyBlock's
+// semantics: the transition out of state_{t-1} into state_t is
+// driven by seq[t-1], not seq[t].
+func (b *Block) forwardLogAlpha(seq []byte, startLog []float64, outLog []linalg.Vector,
+	transLogFor func(int) []linalg.Vector) []linalg.Vector {
+	numStates := len(b.Entries)
+	alpha := make([]linalg.Vector, len(seq))
+	alpha[0] = make(linalg.Vector, numStates)
+	for s := 0; s < numStates; s++ {
+		alpha[0][s] = startLog[s] + outLog[s][seq[0]]
+	}
+	for step := 1; step < len(seq); step++ {
+		trans := transLogFor(int(seq[step-1]))
+		alpha[step] = make(linalg.Vector, numStates)
+		for sp := 0; sp < numStates; sp++ {
+			col := make([]float64, numStates)
+			for s := 0; s < numStates; s++ {
+				col[s] = alpha[step-1][s] + trans[s][sp]
+			}
+			alpha[step][sp] = logSumExp(col) + outLog[sp][seq[step]]
+		}
+	}
+	return alpha
+}
+
+// backwardLogBeta runs the log-domain backward algorithm for
+// one sequence, returning beta_t(s) = log P(y_{t+1}.. |
+// state_t=s, x_t..) for every t, with beta_{T-1}(s) = 0. This
+// mirrors ApplyBlock's semantics: the transition out of
+// state_t into state_{t+1} is driven by seq[t], not seq[t+1].
+func (b *Block) backwardLogBeta(seq []byte, outLog []linalg.Vector,
+	transLogFor func(int) []linalg.Vector) []linalg.Vector {
+	numStates := len(b.Entries)
+	beta := make([]linalg.Vector, len(seq))
+	beta[len(seq)-1] = make(linalg.Vector, numStates)
+	for step := len(seq) - 2; step >= 0; step-- {
+		trans := transLogFor(int(seq[step]))
+		beta[step] = make(linalg.Vector, numStates)
+		for s := 0; s < numStates; s++ {
+			col := make([]float64, numStates)
+			for sp := 0; sp < numStates; sp++ {
+				col[sp] = trans[s][sp] + outLog[sp][seq[step+1]] + beta[step+1][sp]
+			}
+			beta[step][s] = logSumExp(col)
+		}
+	}
+	return beta
+}
+
+// normalizeCounts turns a slice of non-negative soft counts
+// into log-probabilities, applying add-smoothing counts of
+// mass to every entry first.
+func normalizeCounts(counts []float64, smoothing float64) linalg.Vector {
+	total := smoothing * float64(len(counts))
+	for _, c := range counts {
+		total += c
+	}
+	res := make(linalg.Vector, len(counts))
+	for i, c := range counts {
+		res[i] = math.Log((c + smoothing) / total)
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package statebrain
+
+import (
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+// realCorpusLogLikelihood computes the corpus log-likelihood by
+// actually driving the block through ApplyBlock, the same code
+// path Trainer and the rest of the package use, rather than
+// through EMInitialize's own forward recursion. LogLikelihood
+// reuses forwardLogAlpha, so a future indexing bug shared by
+// EMInitialize and forwardLogAlpha could pass a before/after
+// check based on LogLikelihood alone; driving ApplyBlock
+// directly catches that class of regression.
+func realCorpusLogLikelihood(b *Block, corpus [][]byte) float64 {
+	alphabetSize := len(b.Entries[0].Output.Vector)
+	var total float64
+	for _, seq := range corpus {
+		if len(seq) == 0 {
+			continue
+		}
+		state := []rnn.State{b.StartState()}
+		for _, sym := range seq {
+			in := make(linalg.Vector, alphabetSize)
+			in[sym] = 1
+			result := b.ApplyBlock(state, []autofunc.Result{&autofunc.Variable{Vector: in}})
+			total += result.Outputs()[0][sym]
+			state = result.States()
+		}
+	}
+	return total
+}
+
+func TestEMInitialize(t *testing.T) {
+	corpus := [][]byte{}
+	pattern := []byte{0, 1, 2, 3}
+	for i := 0; i < 20; i++ {
+		corpus = append(corpus, pattern)
+	}
+
+	block := NewBlock(4, 4)
+	before := realCorpusLogLikelihood(block, corpus)
+
+	block.EMInitialize(corpus, 1)
+	after := realCorpusLogLikelihood(block, corpus)
+
+	if after <= before {
+		t.Fatalf("expected EM to improve the real ApplyBlock log-likelihood: before=%f after=%f",
+			before, after)
+	}
+}
@@ -0,0 +1,438 @@
+package statebrain
+
+import (
+	"math"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// logSumExpRows fuses the per-state AddFirst/AddLogDomain
+// chain that ApplyBlock used to build into a single autofunc
+// node. Given a log-domain distribution over states and one
+// row of pre-softmax logits per state, it computes
+//
+//	out[a] = logsumexp_s (stateLogProbs[s] + logSoftmax(rows[s])[a])
+//
+// which is exactly the mixture that ApplyBlock and
+// ApplyBlockR need for both the emitted output and the next
+// state distribution.
+type logSumExpRows struct {
+	StateLogProbs autofunc.Result
+	Rows          []*autofunc.Variable
+
+	rowLogSoftmax []linalg.Vector
+	posterior     []linalg.Vector
+	output        linalg.Vector
+}
+
+// rowLogSoftmaxCache computes logSoftmax(rows[s].Vector) for
+// every row. The result depends only on the rows themselves,
+// so callers that reuse the same rows across many batch
+// elements (e.g. every element sharing an input symbol) can
+// compute it once and pass it to newLogSumExpRows.
+func rowLogSoftmaxCache(rows []*autofunc.Variable) []linalg.Vector {
+	res := make([]linalg.Vector, len(rows))
+	for s, row := range rows {
+		res[s] = linalg.Vector(logSoftmax(row.Vector))
+	}
+	return res
+}
+
+func newLogSumExpRows(stateLogProbs autofunc.Result, rows []*autofunc.Variable,
+	rowLogSoftmax []linalg.Vector) *logSumExpRows {
+	numStates := len(rows)
+	width := len(rows[0].Vector)
+	stateLog := stateLogProbs.Output()
+
+	combined := make([]linalg.Vector, numStates)
+	for s := range rows {
+		vec := make(linalg.Vector, width)
+		for a, lv := range rowLogSoftmax[s] {
+			vec[a] = stateLog[s] + lv
+		}
+		combined[s] = vec
+	}
+
+	output := make(linalg.Vector, width)
+	posterior := make([]linalg.Vector, numStates)
+	for s := range posterior {
+		posterior[s] = make(linalg.Vector, width)
+	}
+	col := make([]float64, numStates)
+	for a := 0; a < width; a++ {
+		for s := 0; s < numStates; s++ {
+			col[s] = combined[s][a]
+		}
+		logSum := logSumExp(col)
+		output[a] = logSum
+		for s := 0; s < numStates; s++ {
+			posterior[s][a] = math.Exp(combined[s][a] - logSum)
+		}
+	}
+
+	return &logSumExpRows{
+		StateLogProbs: stateLogProbs,
+		Rows:          rows,
+		rowLogSoftmax: rowLogSoftmax,
+		posterior:     posterior,
+		output:        output,
+	}
+}
+
+func (l *logSumExpRows) Output() linalg.Vector {
+	return l.output
+}
+
+// PropagateGradient back-propagates through the fused mixture
+// into StateLogProbs and every row variable.
+func (l *logSumExpRows) PropagateGradient(upstream linalg.Vector, grad autofunc.Gradient) {
+	numStates := len(l.Rows)
+	width := len(l.output)
+
+	stateGrad := make(linalg.Vector, numStates)
+	for s := 0; s < numStates; s++ {
+		rowGrad := make(linalg.Vector, width)
+		var rowSum float64
+		for a := 0; a < width; a++ {
+			g := upstream[a] * l.posterior[s][a]
+			rowGrad[a] = g
+			rowSum += g
+		}
+		stateGrad[s] = rowSum
+
+		// Backprop through logSoftmax: dx = dy - softmax(x)*sum(dy).
+		rowInput := make(linalg.Vector, width)
+		for a, lv := range l.rowLogSoftmax[s] {
+			rowInput[a] = rowGrad[a] - math.Exp(lv)*rowSum
+		}
+		l.Rows[s].PropagateGradient(rowInput, grad)
+	}
+
+	l.StateLogProbs.PropagateGradient(stateGrad, grad)
+}
+
+// logSumExpRowsR is the R-operator counterpart of
+// logSumExpRows.
+type logSumExpRowsR struct {
+	StateLogProbs autofunc.RResult
+	Cache         *rowRCache
+
+	posterior []linalg.Vector
+	output    linalg.Vector
+	outputR   linalg.Vector
+}
+
+// rowRCache holds the R-operator quantities for a set of
+// rows that stay fixed across many logSumExpRowsR calls
+// (e.g. every batch element sharing an input symbol).
+type rowRCache struct {
+	Vars        []*autofunc.RVariable
+	LogSoftmax  []linalg.Vector
+	LogSoftmaxR []linalg.Vector
+}
+
+func newRowRCache(rv autofunc.RVector, rows []*autofunc.Variable) *rowRCache {
+	softmaxOp := autofunc.Softmax{}
+	numStates := len(rows)
+	width := len(rows[0].Vector)
+
+	vars := make([]*autofunc.RVariable, numStates)
+	logSm := make([]linalg.Vector, numStates)
+	logSmR := make([]linalg.Vector, numStates)
+	for s, row := range rows {
+		vars[s] = autofunc.NewRVariable(row, rv)
+		logSm[s] = linalg.Vector(logSoftmax(row.Vector))
+
+		rowSoftmax := softmaxOp.ApplyR(rv, vars[s]).Output()
+		var dotR float64
+		for a, p := range rowSoftmax {
+			dotR += p * vars[s].ROutputVec[a]
+		}
+		logSmR[s] = make(linalg.Vector, width)
+		for a := range logSmR[s] {
+			logSmR[s][a] = vars[s].ROutputVec[a] - dotR
+		}
+	}
+
+	return &rowRCache{Vars: vars, LogSoftmax: logSm, LogSoftmaxR: logSmR}
+}
+
+func newLogSumExpRowsR(stateLogProbs autofunc.RResult, cache *rowRCache) *logSumExpRowsR {
+	numStates := len(cache.Vars)
+	width := len(cache.LogSoftmax[0])
+	stateLog := stateLogProbs.Output()
+	stateLogR := stateLogProbs.ROutput()
+
+	combined := make([]linalg.Vector, numStates)
+	combinedR := make([]linalg.Vector, numStates)
+	for s := 0; s < numStates; s++ {
+		vec := make(linalg.Vector, width)
+		vecR := make(linalg.Vector, width)
+		for a, lv := range cache.LogSoftmax[s] {
+			vec[a] = stateLog[s] + lv
+			vecR[a] = stateLogR[s] + cache.LogSoftmaxR[s][a]
+		}
+		combined[s] = vec
+		combinedR[s] = vecR
+	}
+
+	output := make(linalg.Vector, width)
+	outputR := make(linalg.Vector, width)
+	posterior := make([]linalg.Vector, numStates)
+	for s := range posterior {
+		posterior[s] = make(linalg.Vector, width)
+	}
+	col := make([]float64, numStates)
+	for a := 0; a < width; a++ {
+		for s := 0; s < numStates; s++ {
+			col[s] = combined[s][a]
+		}
+		logSum := logSumExp(col)
+		output[a] = logSum
+
+		var derivSum float64
+		for s := 0; s < numStates; s++ {
+			p := math.Exp(combined[s][a] - logSum)
+			posterior[s][a] = p
+			derivSum += p * combinedR[s][a]
+		}
+		outputR[a] = derivSum
+	}
+
+	return &logSumExpRowsR{
+		StateLogProbs: stateLogProbs,
+		Cache:         cache,
+		posterior:     posterior,
+		output:        output,
+		outputR:       outputR,
+	}
+}
+
+func (l *logSumExpRowsR) Output() linalg.Vector {
+	return l.output
+}
+
+func (l *logSumExpRowsR) ROutput() linalg.Vector {
+	return l.outputR
+}
+
+// PropagateRGradient back-propagates through the fused
+// mixture into StateLogProbs and every row variable.
+func (l *logSumExpRowsR) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rgrad autofunc.RGradient, grad autofunc.Gradient) {
+	numStates := len(l.Cache.Vars)
+	width := len(l.output)
+
+	stateLogR := l.StateLogProbs.ROutput()
+	stateGrad := make(linalg.Vector, numStates)
+	stateGradR := make(linalg.Vector, numStates)
+	for s := 0; s < numStates; s++ {
+		rowGrad := make(linalg.Vector, width)
+		rowGradR := make(linalg.Vector, width)
+		var rowSum, rowSumR float64
+		for a := 0; a < width; a++ {
+			p := l.posterior[s][a]
+			pR := p * (stateLogR[s] + l.Cache.LogSoftmaxR[s][a] - l.outputR[a])
+			g := upstream[a] * p
+			rowGrad[a] = g
+			rowSum += g
+
+			gR := upstreamR[a]*p + upstream[a]*pR
+			rowGradR[a] = gR
+			rowSumR += gR
+		}
+		stateGrad[s] = rowSum
+		stateGradR[s] = rowSumR
+
+		rowInput := make(linalg.Vector, width)
+		rowInputR := make(linalg.Vector, width)
+		for a, lv := range l.Cache.LogSoftmax[s] {
+			smax := math.Exp(lv)
+			smaxR := smax * l.Cache.LogSoftmaxR[s][a]
+			rowInput[a] = rowGrad[a] - smax*rowSum
+			rowInputR[a] = rowGradR[a] - smax*rowSumR - smaxR*rowSum
+		}
+		l.Cache.Vars[s].PropagateRGradient(rowInput, rowInputR, rgrad, grad)
+	}
+
+	l.StateLogProbs.PropagateRGradient(stateGrad, stateGradR, rgrad, grad)
+}
+
+// logMixture computes a logsumexp-weighted mixture of
+// already-log-domain rows:
+//
+//	out[a] = logsumexp_s (weights[s] + rows[s][a])
+//
+// Unlike logSumExpRows, the rows here need not be raw
+// pre-softmax variables; they can be arbitrary autofunc
+// Results, such as the output of another logMixture. This is
+// what Block.SoftInput uses to marginalize over the alphabet
+// before marginalizing over states.
+type logMixture struct {
+	Weights   autofunc.Result
+	Rows      []autofunc.Result
+	posterior []linalg.Vector
+	output    linalg.Vector
+}
+
+func newLogMixture(weights autofunc.Result, rows []autofunc.Result) *logMixture {
+	numRows := len(rows)
+	width := len(rows[0].Output())
+	w := weights.Output()
+
+	combined := make([]linalg.Vector, numRows)
+	for s, row := range rows {
+		vec := make(linalg.Vector, width)
+		rowVec := row.Output()
+		for a := range vec {
+			vec[a] = w[s] + rowVec[a]
+		}
+		combined[s] = vec
+	}
+
+	output := make(linalg.Vector, width)
+	posterior := make([]linalg.Vector, numRows)
+	for s := range posterior {
+		posterior[s] = make(linalg.Vector, width)
+	}
+	col := make([]float64, numRows)
+	for a := 0; a < width; a++ {
+		for s := 0; s < numRows; s++ {
+			col[s] = combined[s][a]
+		}
+		logSum := logSumExp(col)
+		output[a] = logSum
+		for s := 0; s < numRows; s++ {
+			posterior[s][a] = math.Exp(combined[s][a] - logSum)
+		}
+	}
+
+	return &logMixture{Weights: weights, Rows: rows, posterior: posterior, output: output}
+}
+
+func (l *logMixture) Output() linalg.Vector {
+	return l.output
+}
+
+func (l *logMixture) PropagateGradient(upstream linalg.Vector, grad autofunc.Gradient) {
+	numRows := len(l.Rows)
+	width := len(l.output)
+
+	weightGrad := make(linalg.Vector, numRows)
+	for s := 0; s < numRows; s++ {
+		rowGrad := make(linalg.Vector, width)
+		var sum float64
+		for a := 0; a < width; a++ {
+			g := upstream[a] * l.posterior[s][a]
+			rowGrad[a] = g
+			sum += g
+		}
+		weightGrad[s] = sum
+		l.Rows[s].PropagateGradient(rowGrad, grad)
+	}
+
+	l.Weights.PropagateGradient(weightGrad, grad)
+}
+
+// logMixtureR is the R-operator counterpart of logMixture.
+type logMixtureR struct {
+	Weights   autofunc.RResult
+	Rows      []autofunc.RResult
+	posterior []linalg.Vector
+	output    linalg.Vector
+	outputR   linalg.Vector
+}
+
+func newLogMixtureR(weights autofunc.RResult, rows []autofunc.RResult) *logMixtureR {
+	numRows := len(rows)
+	width := len(rows[0].Output())
+	w := weights.Output()
+	wR := weights.ROutput()
+
+	combined := make([]linalg.Vector, numRows)
+	combinedR := make([]linalg.Vector, numRows)
+	for s, row := range rows {
+		vec := make(linalg.Vector, width)
+		vecR := make(linalg.Vector, width)
+		rowVec := row.Output()
+		rowVecR := row.ROutput()
+		for a := range vec {
+			vec[a] = w[s] + rowVec[a]
+			vecR[a] = wR[s] + rowVecR[a]
+		}
+		combined[s] = vec
+		combinedR[s] = vecR
+	}
+
+	output := make(linalg.Vector, width)
+	outputR := make(linalg.Vector, width)
+	posterior := make([]linalg.Vector, numRows)
+	for s := range posterior {
+		posterior[s] = make(linalg.Vector, width)
+	}
+	col := make([]float64, numRows)
+	for a := 0; a < width; a++ {
+		for s := 0; s < numRows; s++ {
+			col[s] = combined[s][a]
+		}
+		logSum := logSumExp(col)
+		output[a] = logSum
+
+		var derivSum float64
+		for s := 0; s < numRows; s++ {
+			p := math.Exp(combined[s][a] - logSum)
+			posterior[s][a] = p
+			derivSum += p * combinedR[s][a]
+		}
+		outputR[a] = derivSum
+	}
+
+	return &logMixtureR{
+		Weights:   weights,
+		Rows:      rows,
+		posterior: posterior,
+		output:    output,
+		outputR:   outputR,
+	}
+}
+
+func (l *logMixtureR) Output() linalg.Vector {
+	return l.output
+}
+
+func (l *logMixtureR) ROutput() linalg.Vector {
+	return l.outputR
+}
+
+func (l *logMixtureR) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rgrad autofunc.RGradient, grad autofunc.Gradient) {
+	numRows := len(l.Rows)
+	width := len(l.output)
+
+	wR := l.Weights.ROutput()
+	weightGrad := make(linalg.Vector, numRows)
+	weightGradR := make(linalg.Vector, numRows)
+	for s := 0; s < numRows; s++ {
+		rowVecR := l.Rows[s].ROutput()
+		rowGrad := make(linalg.Vector, width)
+		rowGradR := make(linalg.Vector, width)
+		var sum, sumR float64
+		for a := 0; a < width; a++ {
+			p := l.posterior[s][a]
+			pR := p * (wR[s] + rowVecR[a] - l.outputR[a])
+			g := upstream[a] * p
+			rowGrad[a] = g
+			sum += g
+
+			gR := upstreamR[a]*p + upstream[a]*pR
+			rowGradR[a] = gR
+			sumR += gR
+		}
+		weightGrad[s] = sum
+		weightGradR[s] = sumR
+		l.Rows[s].PropagateRGradient(rowGrad, rowGradR, rgrad, grad)
+	}
+
+	l.Weights.PropagateRGradient(weightGrad, weightGradR, rgrad, grad)
+}
@@ -0,0 +1,80 @@
+// Command train_block trains a statebrain.Block on a text
+// corpus and writes the result to a file the analyze command
+// can read.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/unixpickle/statebrain"
+	"github.com/unixpickle/statebrain/trainer"
+)
+
+func main() {
+	var alphabetSize int
+	var stateCount int
+	var epochs int
+	var batchSize int
+	var seqLen int
+	var stepSize float64
+	var outFile string
+
+	flag.IntVar(&alphabetSize, "alphabet", 256, "number of distinct bytes in the alphabet")
+	flag.IntVar(&stateCount, "states", 20, "number of hidden states")
+	flag.IntVar(&epochs, "epochs", 100, "number of training epochs")
+	flag.IntVar(&batchSize, "batch", 16, "sequences per gradient step")
+	flag.IntVar(&seqLen, "seqlen", 200, "length of each training sequence window")
+	flag.Float64Var(&stepSize, "step", 0.001, "gradient descent step size")
+	flag.StringVar(&outFile, "out", "block_out", "file to write the trained block to")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] corpus_file")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	rawCorpus, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read corpus:", err)
+		os.Exit(1)
+	}
+	corpus := splitCorpus(rawCorpus, seqLen)
+
+	block := statebrain.NewBlock(alphabetSize, stateCount)
+	t := trainer.NewTrainer(block, alphabetSize, corpus, stepSize, batchSize)
+
+	t.Train(epochs, func(epoch int, cost float64) bool {
+		fmt.Printf("epoch %d: cost=%f\n", epoch, cost)
+		return true
+	})
+
+	data, err := block.Serialize()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to serialize block:", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(outFile, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write block:", err)
+		os.Exit(1)
+	}
+}
+
+// splitCorpus breaks a single byte stream into fixed-length
+// sequence windows, so Trainer has more than one sample to
+// batch and shuffle over instead of one huge sequence.
+func splitCorpus(data []byte, seqLen int) [][]byte {
+	var res [][]byte
+	for len(data) > 0 {
+		n := seqLen
+		if n > len(data) {
+			n = len(data)
+		}
+		res = append(res, data[:n])
+		data = data[n:]
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+// Package trainer trains statebrain.Block models with
+// gradient descent.
+package trainer
+
+import (
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/sgd"
+	"github.com/unixpickle/statebrain"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn/seqtoseq"
+)
+
+// A Trainer trains a statebrain.Block on a corpus of byte
+// sequences. Each sequence is treated as both the input and
+// the target output, since the Block predicts the current
+// byte from its hidden state before consuming it.
+type Trainer struct {
+	Block *statebrain.Block
+
+	// StepSize is the learning rate for the outer RMSProp
+	// loop.
+	StepSize float64
+
+	// BatchSize is the number of sequences averaged
+	// together for each gradient step.
+	BatchSize int
+
+	// Samples is the training corpus.
+	Samples sgd.SampleSet
+
+	// AlphabetSize is the number of distinct bytes the
+	// Block was created with.
+	AlphabetSize int
+}
+
+// NewTrainer creates a Trainer for a corpus of raw byte
+// sequences. Each sequence becomes a seqtoseq.Sample whose
+// inputs and outputs are one-hot encodings of the bytes in
+// the alphabet [0, alphabetSize).
+func NewTrainer(block *statebrain.Block, alphabetSize int, corpus [][]byte, stepSize float64,
+	batchSize int) *Trainer {
+	var samples sgd.SliceSampleSet
+	for _, seq := range corpus {
+		samples = append(samples, oneHotSample(seq, alphabetSize))
+	}
+	return &Trainer{
+		Block:        block,
+		StepSize:     stepSize,
+		BatchSize:    batchSize,
+		Samples:      samples,
+		AlphabetSize: alphabetSize,
+	}
+}
+
+// Train runs epochs of RMSProp gradient descent over the
+// corpus, invoking status after every epoch with the epoch
+// index and the total cost over the whole corpus at the end
+// of that epoch. If status returns false, training stops
+// early.
+func (t *Trainer) Train(epochs int, status func(epoch int, cost float64) bool) {
+	cost := neuralnet.DotCost{}
+	gradienter := &sgd.RMSProp{
+		Gradienter: &seqtoseq.Gradienter{
+			Learner:  t.Block,
+			Block:    t.Block,
+			CostFunc: cost,
+		},
+	}
+
+	for epoch := 0; epoch < epochs; epoch++ {
+		sgd.ShuffleSampleSet(t.Samples)
+		for i := 0; i < t.Samples.Len(); i += t.BatchSize {
+			bs := t.BatchSize
+			if i+bs > t.Samples.Len() {
+				bs = t.Samples.Len() - i
+			}
+			batch := t.Samples.Subset(i, i+bs)
+			grad := gradienter.Gradient(batch)
+			grad.AddToVars(-t.StepSize)
+		}
+		if status != nil {
+			total := seqtoseq.TotalCostBlock(t.Block, t.BatchSize, t.Samples, cost)
+			if !status(epoch, total) {
+				return
+			}
+		}
+	}
+}
+
+// oneHotSample converts a byte sequence into a
+// seqtoseq.Sample whose inputs and outputs are one-hot
+// vectors over the alphabet.
+func oneHotSample(seq []byte, alphabetSize int) seqtoseq.Sample {
+	vecs := make([]linalg.Vector, len(seq))
+	for i, b := range seq {
+		vec := make(linalg.Vector, alphabetSize)
+		vec[int(b)] = 1
+		vecs[i] = vec
+	}
+	return seqtoseq.Sample{Inputs: vecs, Outputs: vecs}
+}
@@ -0,0 +1,31 @@
+package trainer
+
+import (
+	"testing"
+
+	"github.com/unixpickle/statebrain"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn/seqtoseq"
+)
+
+func TestTrainerReducesCost(t *testing.T) {
+	const alphabetSize = 4
+	pattern := []byte{0, 1, 2, 3}
+	corpus := make([][]byte, 20)
+	for i := range corpus {
+		corpus[i] = pattern
+	}
+
+	block := statebrain.NewBlock(alphabetSize, 4)
+	tr := NewTrainer(block, alphabetSize, corpus, 0.01, 10)
+
+	cost := neuralnet.DotCost{}
+	before := seqtoseq.TotalCostBlock(block, tr.BatchSize, tr.Samples, cost)
+
+	tr.Train(30, nil)
+
+	after := seqtoseq.TotalCostBlock(block, tr.BatchSize, tr.Samples, cost)
+	if after >= before {
+		t.Fatalf("expected training to reduce cost: before=%f after=%f", before, after)
+	}
+}
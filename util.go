@@ -2,6 +2,7 @@ package statebrain
 
 import (
 	"math"
+	"math/rand"
 
 	"github.com/unixpickle/num-analysis/linalg"
 )
@@ -17,3 +18,62 @@ func maxIndex(v linalg.Vector) int {
 	}
 	return res
 }
+
+// logSoftmax computes the log of the softmax of v.
+func logSoftmax(v linalg.Vector) []float64 {
+	max := v[maxIndex(v)]
+	var sum float64
+	for _, x := range v {
+		sum += math.Exp(x - max)
+	}
+	logSum := max + math.Log(sum)
+	res := make([]float64, len(v))
+	for i, x := range v {
+		res[i] = x - logSum
+	}
+	return res
+}
+
+// expNormalize converts a slice of log-probabilities (not
+// necessarily normalized) into normalized probabilities.
+func expNormalize(logVals []float64) []float64 {
+	max := logVals[maxIndex(linalg.Vector(logVals))]
+	res := make([]float64, len(logVals))
+	var sum float64
+	for i, x := range logVals {
+		res[i] = math.Exp(x - max)
+		sum += res[i]
+	}
+	for i := range res {
+		res[i] /= sum
+	}
+	return res
+}
+
+// logSumExp computes log(sum(exp(v))) in a numerically
+// stable way.
+func logSumExp(v []float64) float64 {
+	max := v[maxIndex(linalg.Vector(v))]
+	if math.IsInf(max, -1) {
+		return max
+	}
+	var sum float64
+	for _, x := range v {
+		sum += math.Exp(x - max)
+	}
+	return max + math.Log(sum)
+}
+
+// sampleIndex samples an index from a normalized
+// probability distribution.
+func sampleIndex(probs []float64) int {
+	r := rand.Float64()
+	var cum float64
+	for i, p := range probs {
+		cum += p
+		if r <= cum {
+			return i
+		}
+	}
+	return len(probs) - 1
+}